@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	guardianGGBucket = []byte("guardian_gg")
+	dtrBucket        = []byte("dtr")
+	pgcrBucket       = []byte("pgcr")
+)
+
+// Per-source TTLs, named after where the data comes from and how often it
+// actually changes.
+const (
+	guardianGGTTL = 1 * time.Hour  // ELO drifts within a trials weekend
+	dtrTTL        = 24 * time.Hour // flawless counts update roughly daily
+)
+
+// StatsCache fronts the slow-moving upstream APIs so repeat runs against the
+// same gamertag (or the same opponents across games) don't re-fetch data
+// that hasn't changed. PGCRs are cached forever since completed matches are
+// immutable; Guardian.gg and DTR responses get their own TTLs.
+type StatsCache interface {
+	GetGuardianGGInfo(accountID string) (*GuardianGGResponse, bool)
+	SetGuardianGGInfo(accountID string, resp *GuardianGGResponse)
+	GetDTRInfo(accountID string) (*DTRResponse, bool)
+	SetDTRInfo(accountID string, resp *DTRResponse)
+	GetPGCR(instanceID string) (*PGCRResponse, bool)
+	SetPGCR(instanceID string, pgcr *PGCRResponse)
+	Close() error
+}
+
+// noopCache never hits, never stores; it backs the -no-cache flag.
+type noopCache struct{}
+
+func (noopCache) GetGuardianGGInfo(string) (*GuardianGGResponse, bool) { return nil, false }
+func (noopCache) SetGuardianGGInfo(string, *GuardianGGResponse)        {}
+func (noopCache) GetDTRInfo(string) (*DTRResponse, bool)               { return nil, false }
+func (noopCache) SetDTRInfo(string, *DTRResponse)                      {}
+func (noopCache) GetPGCR(string) (*PGCRResponse, bool)                 { return nil, false }
+func (noopCache) SetPGCR(string, *PGCRResponse)                        {}
+func (noopCache) Close() error                                         { return nil }
+
+type cacheEntry struct {
+	Value     json.RawMessage
+	ExpiresAt time.Time // zero means never expires
+}
+
+func (e *cacheEntry) expired() bool {
+	return !e.ExpiresAt.IsZero() && time.Now().After(e.ExpiresAt)
+}
+
+// boltCache persists entries to a local bbolt file so caching survives
+// between runs of the tool.
+type boltCache struct {
+	db *bbolt.DB
+}
+
+// NewBoltCache opens (creating if necessary) a bbolt-backed StatsCache at path.
+func NewBoltCache(path string) (StatsCache, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening cache db at %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{guardianGGBucket, dtrBucket, pgcrBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing cache buckets: %w", err)
+	}
+	return &boltCache{db: db}, nil
+}
+
+func (c *boltCache) get(bucket []byte, key string, out interface{}) bool {
+	var found bool
+	c.db.View(func(tx *bbolt.Tx) error {
+		raw := tx.Bucket(bucket).Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+		var entry cacheEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nil
+		}
+		if entry.expired() {
+			return nil
+		}
+		if err := json.Unmarshal(entry.Value, out); err != nil {
+			return nil
+		}
+		found = true
+		return nil
+	})
+	return found
+}
+
+func (c *boltCache) set(bucket []byte, key string, value interface{}, ttl time.Duration) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	entryRaw, err := json.Marshal(cacheEntry{Value: raw, ExpiresAt: expiresAt})
+	if err != nil {
+		return
+	}
+	c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), entryRaw)
+	})
+}
+
+func (c *boltCache) GetGuardianGGInfo(accountID string) (*GuardianGGResponse, bool) {
+	var resp GuardianGGResponse
+	if c.get(guardianGGBucket, accountID, &resp) {
+		return &resp, true
+	}
+	return nil, false
+}
+
+func (c *boltCache) SetGuardianGGInfo(accountID string, resp *GuardianGGResponse) {
+	c.set(guardianGGBucket, accountID, resp, guardianGGTTL)
+}
+
+// dtrCacheEntry mirrors DTRResponse for caching purposes, but with Flawless
+// given a real JSON tag. DTRResponse.Flawless is json:"-" so it survives
+// GetDTRInfo's one-off array decoding untouched by the normal (un)marshal
+// path; round-tripping *DTRResponse directly through the cache would marshal
+// it, drop Flawless, and hand back zeroed flawless counts on every cache hit.
+type dtrCacheEntry struct {
+	DTRResponse
+	Flawless DTRFlawlessHistory `json:"flawless"`
+}
+
+func (c *boltCache) GetDTRInfo(accountID string) (*DTRResponse, bool) {
+	var entry dtrCacheEntry
+	if !c.get(dtrBucket, accountID, &entry) {
+		return nil, false
+	}
+	resp := entry.DTRResponse
+	resp.Flawless = entry.Flawless
+	return &resp, true
+}
+
+func (c *boltCache) SetDTRInfo(accountID string, resp *DTRResponse) {
+	c.set(dtrBucket, accountID, dtrCacheEntry{DTRResponse: *resp, Flawless: resp.Flawless}, dtrTTL)
+}
+
+func (c *boltCache) GetPGCR(instanceID string) (*PGCRResponse, bool) {
+	var pgcr PGCRResponse
+	if c.get(pgcrBucket, instanceID, &pgcr) {
+		return &pgcr, true
+	}
+	return nil, false
+}
+
+// SetPGCR stores with no TTL: completed matches never change.
+func (c *boltCache) SetPGCR(instanceID string, pgcr *PGCRResponse) {
+	c.set(pgcrBucket, instanceID, pgcr, 0)
+}
+
+func (c *boltCache) Close() error {
+	return c.db.Close()
+}
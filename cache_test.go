@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Flawless is tagged json:"-" on DTRResponse (see the comment on
+// DTRFlawlessHistory), so caching *DTRResponse directly would silently drop
+// it on every round-trip. This pins that SetDTRInfo/GetDTRInfo preserve it.
+func TestBoltCacheDTRInfoRoundTrip(t *testing.T) {
+	cache, err := NewBoltCache(filepath.Join(t.TempDir(), "stats.db"))
+	if err != nil {
+		t.Fatalf("NewBoltCache: %v", err)
+	}
+	defer cache.Close()
+
+	want := &DTRResponse{
+		MembershipID: "4611686018427386913",
+		DisplayName:  "someguardian",
+	}
+	want.Flawless.Years = map[string]struct {
+		Count      int
+		Characters map[string]struct {
+			Count int
+		}
+	}{
+		"3": {Count: 7},
+	}
+
+	cache.SetDTRInfo(want.MembershipID, want)
+
+	got, ok := cache.GetDTRInfo(want.MembershipID)
+	if !ok {
+		t.Fatalf("GetDTRInfo(%s): not found after Set", want.MembershipID)
+	}
+	if got.Flawless.Years["3"].Count != 7 {
+		t.Errorf("Flawless.Years[\"3\"].Count = %d, want 7", got.Flawless.Years["3"].Count)
+	}
+	if got.DisplayName != want.DisplayName {
+		t.Errorf("DisplayName = %q, want %q", got.DisplayName, want.DisplayName)
+	}
+}
@@ -1,39 +1,64 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"math"
 	"os"
-	"text/tabwriter"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
 	"time"
 )
 
 const TrialsOfOsiris = "14"
 
-var client http.Client
+var bungie *BungieClient
 
 var (
 	system   = flag.String("platform", "", "the platform you play on")
 	gamertag = flag.String("gamertag", "", "your gamertag")
 	apiKey   = flag.String("apikey", os.Getenv("BNETAPI"), "bnet api key")
 	count    = flag.Int("count", 1, "how many games to check (on each character)")
+	cacheDir = flag.String("cache-dir", defaultCacheDir(), "directory for the on-disk stats cache")
+	noCache  = flag.Bool("no-cache", false, "disable the on-disk stats cache")
+
+	pgcrWorkers  = flag.Int("pgcr-workers", 4, "number of concurrent PGCR fetches")
+	statsWorkers = flag.Int("stats-workers", 8, "number of concurrent opponent stats fetches")
+
+	rulesPath = flag.String("rules", "", "path to a YAML file of carry-condition rules (default: built-in rules)")
+
+	serve      = flag.Bool("serve", false, "run as a daemon that polls a watchlist and serves results over HTTP")
+	watchlist  = flag.String("watchlist", "", "comma-separated gamertags to poll in -serve mode (defaults to -gamertag)")
+	interval   = flag.Duration("interval", 15*time.Minute, "how often to poll the watchlist in -serve mode")
+	addr       = flag.String("addr", ":8080", "address to listen on in -serve mode")
+	maxCarries = flag.Int("max-carries", 500, "most flagged games the /carries endpoint retains in -serve mode")
+	maxPlayers = flag.Int("max-players", 2000, "most distinct opponents the /player endpoint retains in -serve mode")
+
+	format       = flag.String("format", "text", "output format: text, json, csv, or template")
+	templateText = flag.String("template", "", "text/template string to render, required when -format=template")
+
+	bungieRPS   = flag.Float64("bungie-rps", DefaultRateLimits().Bungie.RequestsPerSecond, "requests/sec allowed against bungie.net")
+	bungieBurst = flag.Int("bungie-burst", DefaultRateLimits().Bungie.Burst, "burst size allowed against bungie.net")
+	ggRPS       = flag.Float64("gg-rps", DefaultRateLimits().GuardianGG.RequestsPerSecond, "requests/sec allowed against guardian.gg")
+	ggBurst     = flag.Int("gg-burst", DefaultRateLimits().GuardianGG.Burst, "burst size allowed against guardian.gg")
+	dtrRPS      = flag.Float64("dtr-rps", DefaultRateLimits().DTR.RequestsPerSecond, "requests/sec allowed against destinytrialsreport.com")
+	dtrBurst    = flag.Int("dtr-burst", DefaultRateLimits().DTR.Burst, "burst size allowed against destinytrialsreport.com")
 )
 
-var cache map[string]*PlayerStats
+var statsCache StatsCache
 
-type apiTransport struct {
-	apiKey string
-}
-
-func (a *apiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Add("X-API-Key", a.apiKey)
-	return http.DefaultTransport.RoundTrip(req)
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".destiny-carry-check-cache"
+	}
+	return filepath.Join(dir, "destiny-carry-check")
 }
 
 type CarryCondition struct {
@@ -136,25 +161,6 @@ type ActivityHistoryResponse struct {
 	MessageData     interface{}
 }
 
-func GetActivityHistory(console int, accountID string, characterID string, count int, page int, mode string) *ActivityHistoryResponse {
-	baseURL := "https://www.bungie.net/Platform/Destiny/Stats/ActivityHistory/%d/%s/%s/?page=%d&count=%d&mode=%s"
-	url := fmt.Sprintf(baseURL, console, accountID, characterID, page, count, mode)
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Println(err)
-	}
-	if resp.Body == nil {
-		log.Println(errors.New("nil body"))
-	}
-	defer resp.Body.Close()
-	r := &ActivityHistoryResponse{}
-	err = json.NewDecoder(resp.Body).Decode(r)
-	if err != nil {
-		log.Println(err)
-	}
-	return r
-}
-
 type PGCRResponse struct {
 	Response struct {
 		Data struct {
@@ -207,25 +213,6 @@ type PGCRResponse struct {
 	MessageData     interface{}
 }
 
-func GetPGCR(activityID string) *PGCRResponse {
-	baseURL := "https://www.bungie.net/Platform/Destiny/Stats/PostGameCarnageReport/%s/"
-	url := fmt.Sprintf(baseURL, activityID)
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Println(err)
-	}
-	if resp.Body == nil {
-		log.Println(errors.New("nil body"))
-	}
-	defer resp.Body.Close()
-	s := &PGCRResponse{}
-	err = json.NewDecoder(resp.Body).Decode(s)
-	if err != nil {
-		log.Println(err)
-	}
-	return s
-}
-
 type GuardianGGResponse struct {
 	StatusCode int
 	Data       struct {
@@ -247,23 +234,18 @@ type GuardianGGResponse struct {
 	}
 }
 
-func GetGuardianGGInfo(accountID string) *GuardianGGResponse {
-	baseURL := "https://api.guardian.gg/v2/players/%s"
-	url := fmt.Sprintf(baseURL, accountID)
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Println(err)
-	}
-	if resp.Body == nil {
-		log.Println(errors.New("nil body"))
-	}
-	defer resp.Body.Close()
-	s := &GuardianGGResponse{}
-	err = json.NewDecoder(resp.Body).Decode(s)
-	if err != nil {
-		log.Println(err)
+// DTRFlawlessHistory is the parsed shape of a DTR player's flawless-run
+// history. It's its own named type (rather than an inline struct on
+// DTRResponse) so the bolt cache can serialize it under a normal JSON tag -
+// DTRResponse.Flawless itself is tagged json:"-" because DTR's wire format
+// is an array decoded by a one-off adapter in GetDTRInfo, not this shape.
+type DTRFlawlessHistory struct {
+	Years map[string]struct {
+		Count      int
+		Characters map[string]struct {
+			Count int
+		}
 	}
-	return s
 }
 
 type DTRResponse struct {
@@ -272,18 +254,11 @@ type DTRResponse struct {
 	DisplayName    string
 	Kills          string
 	Deaths         string
-	MatchCount     string `json:"match_count"`
-	KillsY2        string `json:"kills_y2"`
-	DeathsY2       string `json:"deaths_y2"`
-	Flawless       struct {
-		Years map[string]struct {
-			Count      int
-			Characters map[string]struct {
-				Count int
-			}
-		}
-	} `json:"-"`
-	ThisWeek []struct {
+	MatchCount     string             `json:"match_count"`
+	KillsY2        string             `json:"kills_y2"`
+	DeathsY2       string             `json:"deaths_y2"`
+	Flawless       DTRFlawlessHistory `json:"-"`
+	ThisWeek       []struct {
 		Matches string
 		Losses  string
 		Kills   string
@@ -291,233 +266,194 @@ type DTRResponse struct {
 	}
 }
 
-func GetDTRInfo(accountID string) *DTRResponse {
-	baseURL := "https://api.destinytrialsreport.com/player/%s"
-	url := fmt.Sprintf(baseURL, accountID)
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Println(err)
-	}
-	if resp.Body == nil {
-		log.Println(errors.New("nil body"))
-	}
-	defer resp.Body.Close()
-	b := &bytes.Buffer{}
-
-	tee := io.TeeReader(resp.Body, b)
+type PlayerStats struct {
+	Name        string
+	ELO, KDR    float64
+	Flawless    int
+	GamesPlayed int
+	WinRate     float64
+}
 
-	type adapter struct {
-		DTRResponse
-		Flawless json.RawMessage
-	}
+func (p *PlayerStats) String() string {
+	return fmt.Sprintf("%s\telo: %.f,\tkdr: %.2f,\tflawless: %d", p.Name, p.ELO, p.KDR, p.Flawless)
+}
 
-	a := []*adapter{&adapter{DTRResponse{}, json.RawMessage{}}}
-	err = json.NewDecoder(tee).Decode(&a)
-	if err != nil {
-		log.Println(err)
-		log.Println(b.String())
-	}
-	if bytes.Compare([]byte(a[0].Flawless), []byte("[]")) != 0 {
-		json.Unmarshal(a[0].Flawless, &a[0].DTRResponse.Flawless)
-	}
-	return &(a[0].DTRResponse)
+// MarshalJSON guards against encoding/json's refusal to encode NaN/±Inf.
+// KDR and WinRate are guarded at the source against the division that would
+// produce them (see GetStatsForPlayer), but this is the last line of
+// defense so a single bad upstream value can't take down a whole -format
+// json run or -serve response.
+func (p *PlayerStats) MarshalJSON() ([]byte, error) {
+	type alias PlayerStats
+	sanitized := *p
+	if math.IsNaN(sanitized.KDR) || math.IsInf(sanitized.KDR, 0) {
+		sanitized.KDR = 0
+	}
+	if math.IsNaN(sanitized.WinRate) || math.IsInf(sanitized.WinRate, 0) {
+		sanitized.WinRate = 0
+	}
+	return json.Marshal((*alias)(&sanitized))
 }
 
-func GetTotalTrialsGames(accountID string) int {
-	baseURL := "https://www.bungie.net/Platform/Destiny/Stats/2/%s/%s/?modes=TrialsOfOsiris"
-	characters := GetCharacterIDsForAccount(accountID, "2")
-	total := 0
-	for _, c := range characters {
-		url := fmt.Sprintf(baseURL, accountID, c)
-		resp, err := client.Get(url)
+func GetStatsForPlayer(ctx context.Context, accountID string) (*PlayerStats, error) {
+	g, ok := statsCache.GetGuardianGGInfo(accountID)
+	if !ok {
+		var err error
+		g, err = bungie.GetGuardianGGInfo(ctx, accountID)
 		if err != nil {
-			log.Println(err)
-		}
-		if resp.Body == nil {
-			log.Println(errors.New("nil body"))
-		}
-		defer resp.Body.Close()
-
-		var s struct {
-			Response struct {
-				TrialsOfOsiris struct {
-					AllTime struct {
-						ActivitiesEntered struct {
-							StatID string
-							Basic  struct {
-								Value        float64
-								DisplayValue string
-							}
-						}
-					}
-				}
-			}
+			return nil, fmt.Errorf("fetching guardian.gg stats for %s: %w", accountID, err)
 		}
+		statsCache.SetGuardianGGInfo(accountID, g)
+	}
+	name := g.Data.Name
+	elo := g.Data.Modes[TrialsOfOsiris].ELO
 
-		err = json.NewDecoder(resp.Body).Decode(&s)
+	dtr, ok := statsCache.GetDTRInfo(accountID)
+	if !ok {
+		var err error
+		dtr, err = bungie.GetDTRInfo(ctx, accountID)
 		if err != nil {
-			log.Println(err)
+			return nil, fmt.Errorf("fetching DTR stats for %s: %w", accountID, err)
 		}
-		total += int(s.Response.TrialsOfOsiris.AllTime.ActivitiesEntered.Basic.Value)
+		statsCache.SetDTRInfo(accountID, dtr)
 	}
-	return total
-}
+	flawless := dtr.Flawless.Years["1"].Count + dtr.Flawless.Years["2"].Count + dtr.Flawless.Years["3"].Count
 
-func GetAccountIDForGamertag(gamertag, platform string) string {
-	baseURL := "https://www.bungie.net/Platform/Destiny/SearchDestinyPlayer/%s/%s/"
-	url := fmt.Sprintf(baseURL, platform, gamertag)
-	resp, err := client.Get(url)
-	if err != nil {
-		log.Println(err)
-	}
-	if resp.Body == nil {
-		log.Println(errors.New("nil body"))
-	}
-	defer resp.Body.Close()
-	var s struct {
-		Response []struct {
-			MembershipType int
-			MembershipID   string
-			DisplayName    string
-		}
-		ErrorCode   int
-		ErrorStatus string
+	mode := g.Data.Modes[TrialsOfOsiris]
+	var kdr float64
+	if mode.Deaths > 0 {
+		kdr = float64(mode.Kills) / float64(mode.Deaths)
 	}
-
-	type w struct {
+	var winRate float64
+	if mode.GamesPlayed > 0 {
+		winRate = float64(mode.Wins) / float64(mode.GamesPlayed)
 	}
 
-	err = json.NewDecoder(resp.Body).Decode(&s)
-	if err != nil {
-		log.Println(err)
-	}
-	return s.Response[0].MembershipID
+	return &PlayerStats{
+		Name:        name,
+		ELO:         elo,
+		KDR:         kdr,
+		Flawless:    flawless,
+		GamesPlayed: mode.GamesPlayed,
+		WinRate:     winRate,
+	}, nil
 }
 
-func GetCharacterIDsForAccount(accountID string, platform string) []string {
-	baseURL := "https://www.bungie.net/Platform/Destiny/%s/Account/%s/Summary/"
-	url := fmt.Sprintf(baseURL, platform, accountID)
-	resp, err := client.Get(url)
+func GetTrialsGamesForGamertag(ctx context.Context, gamertag string, count int) ([]*Activity, error) {
+	accountID, err := bungie.GetAccountIDForGamertag(ctx, gamertag, "2")
 	if err != nil {
-		log.Println(err)
+		return nil, fmt.Errorf("resolving account for %s: %w", gamertag, err)
 	}
-	if resp.Body == nil {
-		log.Println(errors.New("nil body"))
-	}
-	defer resp.Body.Close()
-
-	var s struct {
-		Response struct {
-			Data struct {
-				Characters []struct {
-					CharacterBase struct {
-						CharacterID string
-					}
-				}
-			}
-		}
-		ErrorCode   int
-		ErrorStatus string
-	}
-	err = json.NewDecoder(resp.Body).Decode(&s)
+	characterIDs, err := bungie.GetCharacterIDsForAccount(ctx, accountID, "2")
 	if err != nil {
-		log.Println(err)
-	}
-	characters := make([]string, len(s.Response.Data.Characters))
-	for i, c := range s.Response.Data.Characters {
-		characters[i] = c.CharacterBase.CharacterID
-	}
-	return characters
-}
-
-type PlayerStats struct {
-	Name     string
-	ELO, KDR float64
-	Flawless int
-}
-
-func (p *PlayerStats) String() string {
-	return fmt.Sprintf("%s\telo: %.f,\tkdr: %.2f,\tflawless: %d", p.Name, p.ELO, p.KDR, p.Flawless)
-}
-
-func GetStatsForPlayer(accountID string) *PlayerStats {
-	if cached, ok := cache[accountID]; ok {
-		return cached
+		return nil, fmt.Errorf("fetching characters for %s: %w", accountID, err)
 	}
-	g := GetGuardianGGInfo(accountID)
-	name := g.Data.Name
-	elo := g.Data.Modes[TrialsOfOsiris].ELO
-	kdr := float64(g.Data.Modes[TrialsOfOsiris].Kills) / float64(g.Data.Modes[TrialsOfOsiris].Deaths)
-	dtr := GetDTRInfo(accountID)
-	flawless := dtr.Flawless.Years["1"].Count + dtr.Flawless.Years["2"].Count + dtr.Flawless.Years["3"].Count
-	ps := &PlayerStats{name, elo, kdr, flawless}
-	cache[accountID] = ps
-	return ps
-}
-
-func GetTrialsGamesForGamertag(gamertag string, count int) []*Activity {
-	accountID := GetAccountIDForGamertag(gamertag, "2")
-	characterIDs := GetCharacterIDsForAccount(accountID, "2")
 
 	var as []*Activity
 
 	for _, characterID := range characterIDs {
-		r := GetActivityHistory(2, accountID, characterID, count, 0, "TrialsOfOsiris")
+		r, err := bungie.GetActivityHistory(ctx, 2, accountID, characterID, count, 0, "TrialsOfOsiris")
+		if err != nil {
+			return nil, fmt.Errorf("fetching activity history for character %s: %w", characterID, err)
+		}
 		//TODO: less allocations
 		for i := range r.Response.Data.Activities {
 			as = append(as, &r.Response.Data.Activities[i])
 		}
 	}
-	return as
+	return as, nil
 }
 
 func init() {
+	// go test drives its own process setup with its own -test.* flags;
+	// running flag.Parse() (and standing up a real bungie client and
+	// on-disk cache) against the test binary's args would just break `go
+	// test` for this package.
+	if testing.Testing() {
+		return
+	}
 	flag.Parse()
-	cache = make(map[string]*PlayerStats)
-	client = http.Client{Transport: &apiTransport{apiKey: *apiKey}}
+	bungie = NewBungieClient(*apiKey, RateLimits{
+		Bungie:     RateLimit{RequestsPerSecond: *bungieRPS, Burst: *bungieBurst},
+		GuardianGG: RateLimit{RequestsPerSecond: *ggRPS, Burst: *ggBurst},
+		DTR:        RateLimit{RequestsPerSecond: *dtrRPS, Burst: *dtrBurst},
+	})
+
+	if *noCache {
+		statsCache = noopCache{}
+		return
+	}
+	if err := os.MkdirAll(*cacheDir, 0700); err != nil {
+		log.Fatalf("creating cache dir %s: %v", *cacheDir, err)
+	}
+	c, err := NewBoltCache(filepath.Join(*cacheDir, "stats.db"))
+	if err != nil {
+		log.Fatalf("opening stats cache: %v", err)
+	}
+	statsCache = c
+}
+
+func loadCarryChecks() []*CarryCondition {
+	if *rulesPath == "" {
+		return DefaultRules()
+	}
+	loaded, err := LoadRules(*rulesPath)
+	if err != nil {
+		log.Fatalf("loading rules: %v", err)
+	}
+	return loaded
 }
 
 func main() {
+	defer statsCache.Close()
 
-	w := tabwriter.NewWriter(os.Stdout, 4, 8, 1, ' ', 0)
+	carryChecks := loadCarryChecks()
 
-	carryChecks := []*CarryCondition{eloBased, kdrBased, lhBased}
+	if *serve {
+		runServe(carryChecks)
+		return
+	}
 
-	totalCarries := 0
-	totalGames := 0
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	as := GetTrialsGamesForGamertag(*gamertag, *count)
-	totalGames := len(as)
+	as, err := GetTrialsGamesForGamertag(ctx, *gamertag, *count)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	for _, a := range as {
-		myStanding := a.Values.Standing.Basic.Value
-		pgcr := GetPGCR(a.ActivityDetails.InstanceID)
-		players := pgcr.Response.Data.Entries
-		var stats []*PlayerStats
-		for _, player := range players {
-			// only check people on the other team
-			if player.Standing != myStanding {
-				stat := GetStatsForPlayer(player.Player.DestinyUserInfo.MembershipID)
-				stats = append(stats, stat)
-				fmt.Fprintf(w, "%s\n", stat)
-			}
-		}
-		for _, condition := range carryChecks {
-			any := false
-			if condition.Func(stats) {
-				any = true
-				fmt.Fprintf(w, "maybe a carry based on %s\n", condition.Name)
-			}
-			if any {
-				totalCarries += 1
-			}
+	reports := RunPipeline(ctx, as, *pgcrWorkers, *statsWorkers)
+
+	records := make([]*GameRecord, 0, len(reports))
+	for _, report := range reports {
+		if report.Err != nil {
+			fmt.Fprintln(os.Stderr, report.Err)
+			continue
 		}
-		fmt.Fprintln(w, "---")
+		records = append(records, NewGameRecord(report, carryChecks))
+	}
 
+	if err := writeRecords(os.Stdout, records); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
+}
 
-	fmt.Fprintln(w)
-	fmt.Fprintf(w, "total games:\t%d\n", totalGames)
-	fmt.Fprintf(w, "total potential carries:\t%d\n", totalCarries)
-	w.Flush()
+func writeRecords(w io.Writer, records []*GameRecord) error {
+	switch *format {
+	case "text":
+		return WriteText(w, records)
+	case "json":
+		return WriteJSON(w, records)
+	case "csv":
+		return WriteCSV(w, records)
+	case "template":
+		if *templateText == "" {
+			return fmt.Errorf("-format=template requires -template")
+		}
+		return WriteTemplate(w, records, *templateText)
+	default:
+		return fmt.Errorf("unknown -format %q (want text, json, csv, or template)", *format)
+	}
 }
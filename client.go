@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit configures a single rate.Limiter: steady-state requests per
+// second plus how many requests can burst above that rate at once.
+type RateLimit struct {
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// RateLimits configures the three independent limiters a BungieClient
+// enforces, one per upstream.
+type RateLimits struct {
+	Bungie     RateLimit
+	GuardianGG RateLimit
+	DTR        RateLimit
+}
+
+// DefaultRateLimits returns conservative defaults. The Bungie.net limit is
+// the tightest since that's the API key we can get throttled or revoked on.
+func DefaultRateLimits() RateLimits {
+	return RateLimits{
+		Bungie:     RateLimit{RequestsPerSecond: 5, Burst: 5},
+		GuardianGG: RateLimit{RequestsPerSecond: 10, Burst: 10},
+		DTR:        RateLimit{RequestsPerSecond: 10, Burst: 10},
+	}
+}
+
+// BungieClient wraps the HTTP calls this tool makes against bungie.net,
+// guardian.gg and destinytrialsreport.com. Each upstream gets its own rate
+// limiter since they have independent (and independently flaky) quotas.
+type BungieClient struct {
+	apiKey string
+	http   *http.Client
+
+	bungieLimiter *rate.Limiter
+	ggLimiter     *rate.Limiter
+	dtrLimiter    *rate.Limiter
+
+	maxRetries int
+}
+
+// NewBungieClient builds a client rate-limited per limits.
+func NewBungieClient(apiKey string, limits RateLimits) *BungieClient {
+	return &BungieClient{
+		apiKey:        apiKey,
+		http:          &http.Client{},
+		bungieLimiter: rate.NewLimiter(rate.Limit(limits.Bungie.RequestsPerSecond), limits.Bungie.Burst),
+		ggLimiter:     rate.NewLimiter(rate.Limit(limits.GuardianGG.RequestsPerSecond), limits.GuardianGG.Burst),
+		dtrLimiter:    rate.NewLimiter(rate.Limit(limits.DTR.RequestsPerSecond), limits.DTR.Burst),
+		maxRetries:    5,
+	}
+}
+
+// doJSON performs a GET against url, gated by limiter, retrying on 429/5xx
+// with exponential backoff (honoring ThrottleSeconds when the body reports
+// one), and decodes the response body into out.
+func (c *BungieClient) doJSON(ctx context.Context, limiter *rate.Limiter, url string, out interface{}) error {
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if err := limiter.Wait(ctx); err != nil {
+			return fmt.Errorf("rate limiter: %w", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("building request: %w", err)
+		}
+		if c.apiKey != "" {
+			req.Header.Add("X-API-Key", c.apiKey)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("GET %s: %w", url, err)
+			if !c.backoff(ctx, attempt, 0) {
+				return lastErr
+			}
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			retryAfter := retryAfterSeconds(resp)
+			resp.Body.Close()
+			lastErr = fmt.Errorf("GET %s: status %s", url, resp.Status)
+			if !c.backoff(ctx, attempt, retryAfter) {
+				return lastErr
+			}
+			continue
+		}
+
+		if resp.Body == nil {
+			return fmt.Errorf("GET %s: nil response body", url)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("reading body from %s: %w", url, err)
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("GET %s: status %s", url, resp.Status)
+		}
+
+		var envelope struct {
+			ErrorCode       int
+			ThrottleSeconds int
+			ErrorStatus     string
+			Message         string
+		}
+		// Best-effort peek at Bungie's error envelope; not every upstream
+		// (guardian.gg, DTR) uses this shape, so a decode failure here is fine.
+		// ErrorStatus is only ever set by Bungie, so it doubles as "this
+		// envelope is actually present" - guardian.gg/DTR responses decode
+		// to the zero value and fall through untouched.
+		_ = json.Unmarshal(body, &envelope)
+		if envelope.ThrottleSeconds > 0 {
+			lastErr = fmt.Errorf("GET %s: throttled for %ds (%s)", url, envelope.ThrottleSeconds, envelope.ErrorStatus)
+			if !c.backoff(ctx, attempt, envelope.ThrottleSeconds) {
+				return lastErr
+			}
+			continue
+		}
+		if envelope.ErrorStatus != "" && envelope.ErrorStatus != "Success" {
+			return fmt.Errorf("GET %s: bungie error %d (%s): %s", url, envelope.ErrorCode, envelope.ErrorStatus, envelope.Message)
+		}
+
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(out); err != nil {
+			return fmt.Errorf("decoding body from %s: %w", url, err)
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// backoff sleeps before the next retry attempt and reports whether another
+// attempt should be made. minSeconds, when set, overrides the exponential
+// schedule (e.g. Bungie's ThrottleSeconds or a 429's Retry-After).
+func (c *BungieClient) backoff(ctx context.Context, attempt int, minSeconds int) bool {
+	if attempt >= c.maxRetries {
+		return false
+	}
+	wait := time.Duration(1<<uint(attempt)) * time.Second
+	if minSeconds > 0 {
+		wait = time.Duration(minSeconds) * time.Second
+	}
+	wait += time.Duration(rand.Intn(250)) * time.Millisecond
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
+
+func retryAfterSeconds(resp *http.Response) int {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		var seconds int
+		if _, err := fmt.Sscanf(v, "%d", &seconds); err == nil {
+			return seconds
+		}
+	}
+	return 0
+}
+
+func (c *BungieClient) GetActivityHistory(ctx context.Context, console int, accountID, characterID string, count, page int, mode string) (*ActivityHistoryResponse, error) {
+	baseURL := "https://www.bungie.net/Platform/Destiny/Stats/ActivityHistory/%d/%s/%s/?page=%d&count=%d&mode=%s"
+	url := fmt.Sprintf(baseURL, console, accountID, characterID, page, count, mode)
+	r := &ActivityHistoryResponse{}
+	if err := c.doJSON(ctx, c.bungieLimiter, url, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *BungieClient) GetPGCR(ctx context.Context, activityID string) (*PGCRResponse, error) {
+	baseURL := "https://www.bungie.net/Platform/Destiny/Stats/PostGameCarnageReport/%s/"
+	url := fmt.Sprintf(baseURL, activityID)
+	s := &PGCRResponse{}
+	if err := c.doJSON(ctx, c.bungieLimiter, url, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *BungieClient) GetGuardianGGInfo(ctx context.Context, accountID string) (*GuardianGGResponse, error) {
+	baseURL := "https://api.guardian.gg/v2/players/%s"
+	url := fmt.Sprintf(baseURL, accountID)
+	s := &GuardianGGResponse{}
+	if err := c.doJSON(ctx, c.ggLimiter, url, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *BungieClient) GetDTRInfo(ctx context.Context, accountID string) (*DTRResponse, error) {
+	baseURL := "https://api.destinytrialsreport.com/player/%s"
+	url := fmt.Sprintf(baseURL, accountID)
+
+	type adapter struct {
+		DTRResponse
+		Flawless json.RawMessage
+	}
+	a := []*adapter{{}}
+	if err := c.doJSON(ctx, c.dtrLimiter, url, &a); err != nil {
+		return nil, err
+	}
+	if len(a) == 0 {
+		return nil, fmt.Errorf("GET %s: empty response", url)
+	}
+	if !bytes.Equal([]byte(a[0].Flawless), []byte("[]")) && len(a[0].Flawless) > 0 {
+		if err := json.Unmarshal(a[0].Flawless, &a[0].DTRResponse.Flawless); err != nil {
+			return nil, fmt.Errorf("decoding flawless history from %s: %w", url, err)
+		}
+	}
+	return &a[0].DTRResponse, nil
+}
+
+func (c *BungieClient) GetTotalTrialsGames(ctx context.Context, accountID string) (int, error) {
+	baseURL := "https://www.bungie.net/Platform/Destiny/Stats/2/%s/%s/?modes=TrialsOfOsiris"
+	characters, err := c.GetCharacterIDsForAccount(ctx, accountID, "2")
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for _, char := range characters {
+		url := fmt.Sprintf(baseURL, accountID, char)
+		var s struct {
+			Response struct {
+				TrialsOfOsiris struct {
+					AllTime struct {
+						ActivitiesEntered struct {
+							StatID string
+							Basic  struct {
+								Value        float64
+								DisplayValue string
+							}
+						}
+					}
+				}
+			}
+		}
+		if err := c.doJSON(ctx, c.bungieLimiter, url, &s); err != nil {
+			return 0, err
+		}
+		total += int(s.Response.TrialsOfOsiris.AllTime.ActivitiesEntered.Basic.Value)
+	}
+	return total, nil
+}
+
+func (c *BungieClient) GetAccountIDForGamertag(ctx context.Context, gamertag, platform string) (string, error) {
+	baseURL := "https://www.bungie.net/Platform/Destiny/SearchDestinyPlayer/%s/%s/"
+	url := fmt.Sprintf(baseURL, platform, gamertag)
+	var s struct {
+		Response []struct {
+			MembershipType int
+			MembershipID   string
+			DisplayName    string
+		}
+		ErrorCode   int
+		ErrorStatus string
+	}
+	if err := c.doJSON(ctx, c.bungieLimiter, url, &s); err != nil {
+		return "", err
+	}
+	if len(s.Response) == 0 {
+		return "", fmt.Errorf("no destiny player found for gamertag %q on platform %s", gamertag, platform)
+	}
+	return s.Response[0].MembershipID, nil
+}
+
+func (c *BungieClient) GetCharacterIDsForAccount(ctx context.Context, accountID, platform string) ([]string, error) {
+	baseURL := "https://www.bungie.net/Platform/Destiny/%s/Account/%s/Summary/"
+	url := fmt.Sprintf(baseURL, platform, accountID)
+	var s struct {
+		Response struct {
+			Data struct {
+				Characters []struct {
+					CharacterBase struct {
+						CharacterID string
+					}
+				}
+			}
+		}
+		ErrorCode   int
+		ErrorStatus string
+	}
+	if err := c.doJSON(ctx, c.bungieLimiter, url, &s); err != nil {
+		return nil, err
+	}
+	characters := make([]string, len(s.Response.Data.Characters))
+	for i, char := range s.Response.Data.Characters {
+		characters[i] = char.CharacterBase.CharacterID
+	}
+	return characters, nil
+}
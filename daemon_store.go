@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	daemonGamesBucket   = []byte("daemon_games")
+	daemonCarriesBucket = []byte("daemon_carries")
+	daemonPlayersBucket = []byte("daemon_players")
+)
+
+var daemonCarriesKey = []byte("carries")
+var daemonPlayersKey = []byte("players")
+
+// daemonStore persists the Daemon's accumulated games/carries/players to a
+// local bbolt file so a restart doesn't lose everything the poll loop has
+// built up - mirroring how boltCache persists upstream responses, but for
+// the daemon's own evaluated results rather than raw API responses.
+type daemonStore struct {
+	db *bbolt.DB
+}
+
+// NewDaemonStore opens (creating if necessary) a bbolt-backed daemonStore at path.
+func NewDaemonStore(path string) (*daemonStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening daemon store at %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{daemonGamesBucket, daemonCarriesBucket, daemonPlayersBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initializing daemon store buckets: %w", err)
+	}
+	return &daemonStore{db: db}, nil
+}
+
+// Load restores a previously persisted Daemon state (e.g. after a restart).
+// Missing or corrupt entries are left at their zero value rather than
+// failing the whole load - stale state is better than refusing to start.
+func (s *daemonStore) Load(d *Daemon) {
+	s.db.View(func(tx *bbolt.Tx) error {
+		tx.Bucket(daemonGamesBucket).ForEach(func(k, v []byte) error {
+			var records []*GameRecord
+			if json.Unmarshal(v, &records) == nil {
+				d.games[string(k)] = records
+			}
+			return nil
+		})
+		if raw := tx.Bucket(daemonCarriesBucket).Get(daemonCarriesKey); raw != nil {
+			var carries []*CarryRecord
+			if json.Unmarshal(raw, &carries) == nil {
+				d.carries = carries
+			}
+		}
+		if raw := tx.Bucket(daemonPlayersBucket).Get(daemonPlayersKey); raw != nil {
+			var state daemonPlayersState
+			if json.Unmarshal(raw, &state) == nil {
+				d.players = state.Players
+				d.playerOrder = state.Order
+			}
+		}
+		return nil
+	})
+}
+
+// SaveGames persists the latest records for a single gamertag.
+func (s *daemonStore) SaveGames(gamertag string, records []*GameRecord) {
+	raw, err := json.Marshal(records)
+	if err != nil {
+		return
+	}
+	s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(daemonGamesBucket).Put([]byte(gamertag), raw)
+	})
+}
+
+// SaveCarries persists the whole (already-capped) carries slice.
+func (s *daemonStore) SaveCarries(carries []*CarryRecord) {
+	raw, err := json.Marshal(carries)
+	if err != nil {
+		return
+	}
+	s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(daemonCarriesBucket).Put(daemonCarriesKey, raw)
+	})
+}
+
+// daemonPlayersState is players plus its LRU order, so the eviction order
+// used by Daemon.recordPlayer survives a restart instead of resetting.
+type daemonPlayersState struct {
+	Players map[string]*PlayerStats `json:"players"`
+	Order   []string                `json:"order"`
+}
+
+// SavePlayers persists the membership ID -> last seen stats map, plus the
+// LRU order used to cap it.
+func (s *daemonStore) SavePlayers(players map[string]*PlayerStats, order []string) {
+	raw, err := json.Marshal(daemonPlayersState{Players: players, Order: order})
+	if err != nil {
+		return
+	}
+	s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(daemonPlayersBucket).Put(daemonPlayersKey, raw)
+	})
+}
+
+func (s *daemonStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+)
+
+// GameRecord is one machine-readable carry-check result: the shape emitted
+// by -format json/csv/template, and reused by the -serve daemon's JSON
+// endpoints so CLI and HTTP output stay consistent.
+type GameRecord struct {
+	InstanceID     string         `json:"instance_id"`
+	Period         time.Time      `json:"period"`
+	Standing       float64        `json:"standing"`
+	Opponents      []*PlayerStats `json:"opponents"`
+	TriggeredRules []string       `json:"triggered_rules"`
+}
+
+// NewGameRecord builds a GameRecord from a pipeline report, evaluating
+// carryChecks against the report's opponents.
+func NewGameRecord(report *GameReport, carryChecks []*CarryCondition) *GameRecord {
+	return &GameRecord{
+		InstanceID:     report.Activity.ActivityDetails.InstanceID,
+		Period:         report.Activity.Period,
+		Standing:       report.Activity.Values.Standing.Basic.Value,
+		Opponents:      report.Stats,
+		TriggeredRules: TriggeredRules(carryChecks, report.Stats),
+	}
+}
+
+// WriteText renders records the way the CLI always has: a tab-aligned block
+// per game followed by a totals summary.
+func WriteText(w io.Writer, records []*GameRecord) error {
+	tw := tabwriter.NewWriter(w, 4, 8, 1, ' ', 0)
+	totalCarries := 0
+
+	for _, rec := range records {
+		for _, stat := range rec.Opponents {
+			fmt.Fprintf(tw, "%s\n", stat)
+		}
+		for _, name := range rec.TriggeredRules {
+			fmt.Fprintf(tw, "maybe a carry based on %s\n", name)
+		}
+		if len(rec.TriggeredRules) > 0 {
+			totalCarries++
+		}
+		fmt.Fprintln(tw, "---")
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "total games:\t%d\n", len(records))
+	fmt.Fprintf(tw, "total potential carries:\t%d\n", totalCarries)
+	return tw.Flush()
+}
+
+// WriteJSON writes records as a single JSON array.
+func WriteJSON(w io.Writer, records []*GameRecord) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+var csvHeader = []string{"instance_id", "period", "standing", "opponent", "elo", "kdr", "flawless", "triggered_rules"}
+
+// WriteCSV writes one row per opponent (games with no opponents still get a
+// row, with the opponent columns blank) so every row stands alone for
+// spreadsheet import.
+func WriteCSV(w io.Writer, records []*GameRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		triggered := strings.Join(rec.TriggeredRules, ";")
+		if len(rec.Opponents) == 0 {
+			if err := cw.Write([]string{rec.InstanceID, rec.Period.Format(time.RFC3339), formatFloat(rec.Standing), "", "", "", "", triggered}); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, stat := range rec.Opponents {
+			row := []string{
+				rec.InstanceID,
+				rec.Period.Format(time.RFC3339),
+				formatFloat(rec.Standing),
+				stat.Name,
+				formatFloat(stat.ELO),
+				formatFloat(stat.KDR),
+				strconv.Itoa(stat.Flawless),
+				triggered,
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// formatFloat renders f for a CSV cell. KDR
+// and WinRate are guarded against NaN/±Inf at the source (GetStatsForPlayer)
+// and PlayerStats.MarshalJSON sanitizes them for -format json, but CSV
+// doesn't go through MarshalJSON, so guard here too rather than letting a
+// literal "NaN"/"+Inf" slip into a cell.
+func formatFloat(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		f = 0
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// WriteTemplate executes a user-supplied text/template against the full
+// slice of records, so the template controls its own iteration (e.g.
+// {{range .}}...{{end}}).
+func WriteTemplate(w io.Writer, records []*GameRecord, tmplText string) error {
+	tmpl, err := template.New("carry-check").Parse(tmplText)
+	if err != nil {
+		return fmt.Errorf("parsing template: %w", err)
+	}
+	return tmpl.Execute(w, records)
+}
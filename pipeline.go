@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// statsGroup collapses concurrent lookups for the same opponent (they show
+// up across multiple games) into a single upstream call.
+var statsGroup singleflight.Group
+
+func getStatsForPlayerDeduped(ctx context.Context, accountID string) (*PlayerStats, error) {
+	v, err, _ := statsGroup.Do(accountID, func() (interface{}, error) {
+		return GetStatsForPlayer(ctx, accountID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*PlayerStats), nil
+}
+
+// GameReport is the carry-check result for a single activity: the opponents'
+// stats plus Err if the whole game couldn't be evaluated (e.g. the PGCR
+// fetch failed). A single opponent's stats failing to resolve doesn't set
+// Err - that opponent is just dropped from Stats/OpponentIDs, which parallel
+// each other (same index refers to the same opponent) so callers that need
+// the membership ID - the daemon's /player endpoint, say - don't have to
+// re-walk the PGCR.
+type GameReport struct {
+	Activity    *Activity
+	Stats       []*PlayerStats
+	OpponentIDs []string
+	Err         error
+}
+
+type pgcrJob struct {
+	index    int
+	activity *Activity
+}
+
+type pgcrResult struct {
+	index    int
+	activity *Activity
+	pgcr     *PGCRResponse
+	err      error
+}
+
+// RunPipeline fans activities through a PGCR-fetch stage and a stats-fetch
+// stage, each with its own worker pool, and returns one GameReport per
+// activity in the same order they were given. Bounding each stage
+// separately lets PGCR fetches (bulky, bungie.net only) and stats fetches
+// (small, split across guardian.gg/DTR) run at their own pace without one
+// stage starving the other.
+func RunPipeline(ctx context.Context, activities []*Activity, pgcrWorkers, statsWorkers int) []*GameReport {
+	pgcrJobs := make(chan pgcrJob)
+	pgcrResults := make(chan pgcrResult)
+	reports := make([]*GameReport, len(activities))
+
+	var pgcrWG sync.WaitGroup
+	pgcrWG.Add(pgcrWorkers)
+	for i := 0; i < pgcrWorkers; i++ {
+		go func() {
+			defer pgcrWG.Done()
+			for job := range pgcrJobs {
+				res := pgcrResult{index: job.index, activity: job.activity}
+				if cached, ok := statsCache.GetPGCR(job.activity.ActivityDetails.InstanceID); ok {
+					res.pgcr = cached
+				} else {
+					pgcr, err := bungie.GetPGCR(ctx, job.activity.ActivityDetails.InstanceID)
+					if err != nil {
+						res.err = err
+					} else {
+						statsCache.SetPGCR(job.activity.ActivityDetails.InstanceID, pgcr)
+						res.pgcr = pgcr
+					}
+				}
+				pgcrResults <- res
+			}
+		}()
+	}
+	go func() {
+		pgcrWG.Wait()
+		close(pgcrResults)
+	}()
+
+	var statsWG sync.WaitGroup
+	statsWG.Add(statsWorkers)
+	for i := 0; i < statsWorkers; i++ {
+		go func() {
+			defer statsWG.Done()
+			for res := range pgcrResults {
+				reports[res.index] = buildGameReport(ctx, res)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(pgcrJobs)
+		for i, a := range activities {
+			pgcrJobs <- pgcrJob{index: i, activity: a}
+		}
+	}()
+
+	statsWG.Wait()
+	return reports
+}
+
+func buildGameReport(ctx context.Context, res pgcrResult) *GameReport {
+	report := &GameReport{Activity: res.activity}
+	if res.err != nil {
+		report.Err = res.err
+		return report
+	}
+
+	myStanding := res.activity.Values.Standing.Basic.Value
+	for _, player := range res.pgcr.Response.Data.Entries {
+		// only check people on the other team
+		if player.Standing == myStanding {
+			continue
+		}
+		membershipID := player.Player.DestinyUserInfo.MembershipID
+		stat, err := getStatsForPlayerDeduped(ctx, membershipID)
+		if err != nil {
+			// A transient guardian.gg/DTR hiccup on one opponent shouldn't
+			// sink the whole game; report.Err is reserved for failures
+			// (PGCR fetch) that leave us with nothing to evaluate at all.
+			log.Printf("stats for opponent %s in %s: %v", membershipID, res.activity.ActivityDetails.InstanceID, err)
+			continue
+		}
+		report.Stats = append(report.Stats, stat)
+		report.OpponentIDs = append(report.OpponentIDs, membershipID)
+	}
+	return report
+}
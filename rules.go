@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuleConfig is one entry in a -rules YAML file. Which of Threshold/Low/High
+// apply depends on Operator.
+type RuleConfig struct {
+	Name      string  `yaml:"name"`
+	Field     string  `yaml:"field"`
+	Operator  string  `yaml:"operator"`
+	Threshold float64 `yaml:"threshold"`
+	Low       float64 `yaml:"low"`
+	High      float64 `yaml:"high"`
+}
+
+type rulesFile struct {
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+// statField pulls a named field off PlayerStats so rules can be written
+// generically instead of one Go func per stat.
+func statField(p *PlayerStats, field string) (float64, error) {
+	switch field {
+	case "ELO":
+		return p.ELO, nil
+	case "KDR":
+		return p.KDR, nil
+	case "Flawless":
+		return float64(p.Flawless), nil
+	case "GamesPlayed":
+		return float64(p.GamesPlayed), nil
+	case "WinRate":
+		return p.WinRate, nil
+	default:
+		return 0, fmt.Errorf("unknown field %q", field)
+	}
+}
+
+// BuildCarryCondition turns a RuleConfig into a CarryCondition. Supported
+// operators:
+//   - spread: flags when (max - min) across the lobby's field >= Threshold
+//   - min: flags when any player's field <= Threshold
+//   - max: flags when any player's field >= Threshold
+//   - threshold-pair: flags when one player is <= Low and another is >= High
+func BuildCarryCondition(cfg RuleConfig) (*CarryCondition, error) {
+	switch cfg.Operator {
+	case "spread":
+		return &CarryCondition{cfg.Name, func(ps []*PlayerStats) bool {
+			if len(ps) <= 1 {
+				return false
+			}
+			min, max, ok := fieldRange(ps, cfg.Field)
+			return ok && (max-min) >= cfg.Threshold
+		}}, nil
+	case "min":
+		return &CarryCondition{cfg.Name, func(ps []*PlayerStats) bool {
+			return anyField(ps, cfg.Field, func(v float64) bool { return v <= cfg.Threshold })
+		}}, nil
+	case "max":
+		return &CarryCondition{cfg.Name, func(ps []*PlayerStats) bool {
+			return anyField(ps, cfg.Field, func(v float64) bool { return v >= cfg.Threshold })
+		}}, nil
+	case "threshold-pair":
+		return &CarryCondition{cfg.Name, func(ps []*PlayerStats) bool {
+			under := anyField(ps, cfg.Field, func(v float64) bool { return v <= cfg.Low })
+			over := anyField(ps, cfg.Field, func(v float64) bool { return v >= cfg.High })
+			return under && over
+		}}, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %q", cfg.Operator)
+	}
+}
+
+func fieldRange(ps []*PlayerStats, field string) (min, max float64, ok bool) {
+	first := true
+	for _, p := range ps {
+		v, err := statField(p, field)
+		if err != nil {
+			continue
+		}
+		if first {
+			min, max, first = v, v, false
+			continue
+		}
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	return min, max, !first
+}
+
+func anyField(ps []*PlayerStats, field string, match func(float64) bool) bool {
+	for _, p := range ps {
+		v, err := statField(p, field)
+		if err != nil {
+			continue
+		}
+		if match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// TriggeredRules returns the name of every condition in conditions that
+// matches stats, in order.
+func TriggeredRules(conditions []*CarryCondition, stats []*PlayerStats) []string {
+	var names []string
+	for _, condition := range conditions {
+		if condition.Func(stats) {
+			names = append(names, condition.Name)
+		}
+	}
+	return names
+}
+
+// DefaultRules returns the built-in ruleset, matching the tool's behavior
+// before -rules existed.
+func DefaultRules() []*CarryCondition {
+	return []*CarryCondition{eloBased, kdrBased, lhBased}
+}
+
+// LoadRules parses a -rules YAML file into a slice of CarryCondition.
+func LoadRules(path string) ([]*CarryCondition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rules file %s: %w", path, err)
+	}
+	var rf rulesFile
+	if err := yaml.Unmarshal(data, &rf); err != nil {
+		return nil, fmt.Errorf("parsing rules file %s: %w", path, err)
+	}
+	conditions := make([]*CarryCondition, 0, len(rf.Rules))
+	for _, cfg := range rf.Rules {
+		cond, err := BuildCarryCondition(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", cfg.Name, err)
+		}
+		conditions = append(conditions, cond)
+	}
+	return conditions, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runServe starts the -serve daemon: a watchlist poller plus an HTTP
+// reporting endpoint. It runs until SIGINT/SIGTERM, shutting the HTTP
+// server down gracefully and stopping the poll loop.
+func runServe(carryChecks []*CarryCondition) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	gamertags := []string{*gamertag}
+	if *watchlist != "" {
+		gamertags = strings.Split(*watchlist, ",")
+		for i, gt := range gamertags {
+			gamertags[i] = strings.TrimSpace(gt)
+		}
+	}
+
+	daemon := NewDaemon(gamertags, *interval, carryChecks, *count, *maxCarries, *maxPlayers)
+	if !*noCache {
+		store, err := NewDaemonStore(filepath.Join(*cacheDir, "daemon.db"))
+		if err != nil {
+			log.Fatalf("opening daemon store: %v", err)
+		}
+		defer store.Close()
+		daemon.store = store
+		daemon.store.Load(daemon)
+	}
+	go daemon.Run(ctx)
+
+	srv := &http.Server{Addr: *addr, Handler: daemon}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving carry-check reports on %s (watchlist: %s)", *addr, strings.Join(gamertags, ", "))
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// CarryRecord is one flagged game surfaced by the daemon's /carries
+// endpoint: a GameRecord plus the gamertag it was seen on, since /carries
+// spans the whole watchlist.
+type CarryRecord struct {
+	Gamertag string `json:"gamertag"`
+	*GameRecord
+}
+
+// Daemon polls a watchlist of gamertags on an interval and serves the
+// accumulated results over HTTP, so the tool can back a dashboard instead of
+// being run by hand after every Trials session. Unless -no-cache is set,
+// results are also persisted to a daemonStore so a restart doesn't lose
+// everything the poll loop has built up.
+type Daemon struct {
+	watchlist    []string
+	interval     time.Duration
+	carryChecks  []*CarryCondition
+	gamesPerPoll int
+	maxCarries   int
+	maxPlayers   int
+	store        *daemonStore // nil when -no-cache is set; results stay in-memory only
+
+	mu          sync.RWMutex
+	games       map[string][]*GameRecord // gamertag -> most recent records
+	carries     []*CarryRecord           // flagged games across every gamertag, newest first, capped at maxCarries
+	players     map[string]*PlayerStats  // membership ID -> last seen stats
+	playerOrder []string                 // membership IDs, most recently seen first; parallels players for LRU eviction
+}
+
+// NewDaemon builds a Daemon for the given watchlist. Call Run to start
+// polling and ServeHTTP (or just pass the Daemon itself as a http.Handler)
+// to expose the reporting endpoints.
+func NewDaemon(watchlist []string, interval time.Duration, carryChecks []*CarryCondition, gamesPerPoll, maxCarries, maxPlayers int) *Daemon {
+	return &Daemon{
+		watchlist:    watchlist,
+		interval:     interval,
+		carryChecks:  carryChecks,
+		gamesPerPoll: gamesPerPoll,
+		maxCarries:   maxCarries,
+		maxPlayers:   maxPlayers,
+		games:        make(map[string][]*GameRecord),
+		players:      make(map[string]*PlayerStats),
+	}
+}
+
+// Run polls every gamertag on the watchlist once per interval until ctx is
+// canceled. It's meant to be started in its own goroutine.
+func (d *Daemon) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	d.pollAll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.pollAll(ctx)
+		}
+	}
+}
+
+func (d *Daemon) pollAll(ctx context.Context) {
+	for _, gt := range d.watchlist {
+		if err := d.poll(ctx, gt); err != nil {
+			log.Printf("polling %s: %v", gt, err)
+		}
+	}
+}
+
+// recordPlayer stores the last-seen stats for a membership ID and bumps it
+// to the front of the LRU order, evicting the least recently seen player
+// once the daemon has accumulated more than maxPlayers distinct opponents.
+// Without this, every opponent ever encountered across every poll (exactly
+// the "deployed once" use case this mode is for) would be retained forever.
+// Callers must hold d.mu.
+func (d *Daemon) recordPlayer(membershipID string, stat *PlayerStats) {
+	if _, seen := d.players[membershipID]; seen {
+		for i, id := range d.playerOrder {
+			if id == membershipID {
+				d.playerOrder = append(d.playerOrder[:i], d.playerOrder[i+1:]...)
+				break
+			}
+		}
+	}
+	d.players[membershipID] = stat
+	d.playerOrder = append([]string{membershipID}, d.playerOrder...)
+
+	for len(d.playerOrder) > d.maxPlayers {
+		evict := d.playerOrder[len(d.playerOrder)-1]
+		d.playerOrder = d.playerOrder[:len(d.playerOrder)-1]
+		delete(d.players, evict)
+	}
+}
+
+func (d *Daemon) poll(ctx context.Context, gamertag string) error {
+	as, err := GetTrialsGamesForGamertag(ctx, gamertag, d.gamesPerPoll)
+	if err != nil {
+		return fmt.Errorf("fetching games for %s: %w", gamertag, err)
+	}
+	reports := RunPipeline(ctx, as, *pgcrWorkers, *statsWorkers)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := make(map[string]bool, len(d.carries))
+	for _, c := range d.carries {
+		seen[c.Gamertag+"/"+c.InstanceID] = true
+	}
+
+	records := make([]*GameRecord, 0, len(reports))
+	for _, report := range reports {
+		if report.Err != nil {
+			continue
+		}
+		for i, stat := range report.Stats {
+			d.recordPlayer(report.OpponentIDs[i], stat)
+		}
+		record := NewGameRecord(report, d.carryChecks)
+		records = append(records, record)
+		if len(record.TriggeredRules) > 0 && !seen[gamertag+"/"+record.InstanceID] {
+			d.carries = append(d.carries, &CarryRecord{Gamertag: gamertag, GameRecord: record})
+		}
+	}
+	d.games[gamertag] = records
+	sort.SliceStable(d.carries, func(i, j int) bool { return d.carries[i].Period.After(d.carries[j].Period) })
+	if len(d.carries) > d.maxCarries {
+		d.carries = d.carries[:d.maxCarries]
+	}
+
+	if d.store != nil {
+		d.store.SaveGames(gamertag, records)
+		d.store.SaveCarries(d.carries)
+		d.store.SavePlayers(d.players, d.playerOrder)
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler, exposing:
+//
+//	GET /games/{gamertag}  - the most recent reports for that gamertag
+//	GET /carries           - every flagged game across the watchlist, newest first
+//	GET /player/{id}       - the last known stats for a membership ID
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/carries":
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		writeJSON(w, d.carries)
+	case strings.HasPrefix(r.URL.Path, "/games/"):
+		gamertag := strings.TrimPrefix(r.URL.Path, "/games/")
+		d.mu.RLock()
+		records, ok := d.games[gamertag]
+		d.mu.RUnlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, records)
+	case strings.HasPrefix(r.URL.Path, "/player/"):
+		membershipID := strings.TrimPrefix(r.URL.Path, "/player/")
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		stats, ok := d.players[membershipID]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, stats)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}